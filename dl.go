@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -11,21 +14,68 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/tillberg/alog"
 	"github.com/tillberg/stringset"
 )
 
+const (
+	reconnectInitialBackoff = 1 * time.Second
+	idleCheckInterval       = 5 * time.Second
+)
+
 var (
 	serviceWhitelist *stringset.StringSet
 	watchers         = map[string]*Watcher{}
 	watchersMutex    sync.Mutex
 	dockerClient     *client.Client
 	maxServiceLength int
+
+	// idleReconnectThreshold and reconnectMaxBackoff are configurable via flags; see main().
+	// idleReconnectThreshold is deliberately minutes, not seconds: it only sets how long the stream
+	// must be silent before (*Watcher).waitForReattach starts checking, every idleCheckInterval,
+	// whether bytes are piling up in the container's log file that our stream isn't delivering;
+	// it's not itself evidence of a stuck
+	// connection, so it can be generous without causing false-positive reattaches for services
+	// that simply log infrequently.
+	idleReconnectThreshold = 5 * time.Minute
+	reconnectMaxBackoff    = 30 * time.Second
 )
 
 func main() {
-	services := os.Args[1:]
+	flag.DurationVar(&idleReconnectThreshold, "idle-timeout", idleReconnectThreshold, "reattach to a container's logs if no output is seen for this long while it's still running and its log file keeps growing (handles json-file log rotation)")
+	flag.DurationVar(&reconnectMaxBackoff, "max-reconnect-backoff", reconnectMaxBackoff, "maximum backoff between attempts to reattach to a container's logs after an error")
+	flag.StringVar(&watchComposeFile, "watch", "", "compose file to rebuild services from when -rebuild-on paths change")
+	flag.Var(&syncFlags, "sync", "service:hostPath:containerPath; docker cp hostPath into the service's container(s) whenever it changes")
+	flag.Var(&rebuildFlags, "rebuild-on", "service:glob; rebuild the service with `docker compose up --build` whenever a matching file changes")
+	flag.StringVar(&outputFormat, "format", outputFormat, "log output format: \"text\" (colorized, per-service prefixes) or \"json\" (one object per line, to stdout)")
+	flag.StringVar(&defaultTail, "tail", defaultTail, "number of historical log lines to replay when first attaching to a container")
+	flag.DurationVar(&defaultSince, "since", defaultSince, "replay history back to this long ago instead of by line count (overrides -tail)")
+	flag.BoolVar(&defaultNoReplay, "no-replay", defaultNoReplay, "skip historical logs entirely; only follow new lines")
+	flag.Parse()
+	if outputFormat != "text" && outputFormat != "json" {
+		fmt.Fprintf(os.Stderr, "invalid -format %q: must be \"text\" or \"json\"\n", outputFormat)
+		os.Exit(1)
+	}
+	if !validTailValue(defaultTail) {
+		fmt.Fprintf(os.Stderr, "invalid -tail %q: must be \"all\" or a non-negative integer\n", defaultTail)
+		os.Exit(1)
+	}
+	// Each arg is a service name, optionally with a per-service replay override, e.g.
+	// "api:tail=100" or "worker:since=1h"; see parseServiceArg. A malformed override is a
+	// configuration mistake, not a transient condition, so it fails fast here rather than
+	// surfacing later as an endless retry loop against the docker daemon.
+	rawArgs := flag.Args()
+	services := make([]string, 0, len(rawArgs))
+	for _, arg := range rawArgs {
+		service, err := parseServiceArg(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		services = append(services, service)
+	}
 	serviceWhitelist = stringset.New(services...)
 	for _, service := range services {
 		if len(service) > maxServiceLength {
@@ -37,6 +87,9 @@ func main() {
 	alog.BailIf(err)
 	go watchEvents()
 	go watchExisting()
+	if len(syncFlags) > 0 || len(rebuildFlags) > 0 {
+		go watchSources(context.Background())
+	}
 	select {}
 }
 
@@ -127,6 +180,13 @@ type Watcher struct {
 	ContainerID          string
 	cancel               context.CancelFunc
 	wasStartedPreviously bool
+
+	mu           sync.Mutex
+	lastSeenOut  time.Time
+	lastSeenErr  time.Time
+	unsupported  bool
+	lastActivity time.Time
+	lastLogSize  int64
 }
 
 func NewWatcher(service string, containerID string) *Watcher {
@@ -136,55 +196,356 @@ func NewWatcher(service string, containerID string) *Watcher {
 	}
 }
 
+// lastSeen returns the high-water mark recorded for the given stream ("stdout" or "stderr").
+func (w *Watcher) lastSeen(stream string) time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if stream == "stdout" {
+		return w.lastSeenOut
+	}
+	return w.lastSeenErr
+}
+
+// recordSeen updates the high-water mark for the given stream if ts is newer than what's
+// already recorded.
+func (w *Watcher) recordSeen(stream string, ts time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if stream == "stdout" {
+		if ts.After(w.lastSeenOut) {
+			w.lastSeenOut = ts
+		}
+	} else {
+		if ts.After(w.lastSeenErr) {
+			w.lastSeenErr = ts
+		}
+	}
+}
+
+// lastSeenOverall returns the most recent timestamp forwarded across both streams, used as
+// the low-water mark for `Since` on reconnection.
+func (w *Watcher) lastSeenOverall() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lastSeenOut.After(w.lastSeenErr) {
+		return w.lastSeenOut
+	}
+	return w.lastSeenErr
+}
+
+// isUnsupported reports whether this container's log driver doesn't support `docker logs`,
+// in which case there's no point trying to attach again.
+func (w *Watcher) isUnsupported() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.unsupported
+}
+
+func (w *Watcher) setUnsupported() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.unsupported = true
+}
+
+// touchActivity records that a log byte was just received, resetting the idle clock that
+// supervise uses to detect a stuck connection (e.g. across json-file log rotation).
+func (w *Watcher) touchActivity() {
+	w.mu.Lock()
+	w.lastActivity = time.Now()
+	w.mu.Unlock()
+}
+
+func (w *Watcher) activitySince() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastActivity
+}
+
+// containerRunning reports whether the container is still reported as running by the daemon.
+func (w *Watcher) containerRunning(ctx context.Context) bool {
+	info, err := dockerClient.ContainerInspect(ctx, w.ContainerID)
+	if err != nil {
+		return false
+	}
+	return info.State != nil && info.State.Running
+}
+
+// statLogFile returns the current size of the container's json-file log, if the daemon exposes
+// one at a path we can stat directly (true for a local docker daemon).
+func (w *Watcher) statLogFile(ctx context.Context) (int64, bool) {
+	info, err := dockerClient.ContainerInspect(ctx, w.ContainerID)
+	if err != nil || info.LogPath == "" {
+		return 0, false
+	}
+	fi, err := os.Stat(info.LogPath)
+	if err != nil {
+		return 0, false
+	}
+	return fi.Size(), true
+}
+
+// primeLogSize records the log file's current size as the baseline for a later
+// logFileGrewSinceLastCheck call. Call this right before (re)attaching so the first idle check
+// afterwards compares against what existed at attach time, not some earlier connection's data.
+func (w *Watcher) primeLogSize(ctx context.Context) {
+	if size, ok := w.statLogFile(ctx); ok {
+		w.mu.Lock()
+		w.lastLogSize = size
+		w.mu.Unlock()
+	}
+}
+
+// logFileGrewSinceLastCheck reports whether the container's log file has picked up new bytes
+// since the last call (or since primeLogSize), updating the baseline as a side effect. This is
+// what distinguishes "the connection is stuck and logs are piling up unread" from "the service
+// just isn't logging anything right now" — idleReconnectThreshold alone can't tell those apart.
+// If the log file can't be stat'd (e.g. a non-local docker daemon), it conservatively reports
+// growth so idleReconnectThreshold alone still acts as a fallback signal.
+func (w *Watcher) logFileGrewSinceLastCheck(ctx context.Context) bool {
+	size, ok := w.statLogFile(ctx)
+	if !ok {
+		return true
+	}
+	w.mu.Lock()
+	grew := size > w.lastLogSize
+	w.lastLogSize = size
+	w.mu.Unlock()
+	return grew
+}
+
 func (w *Watcher) start(startTime time.Time) {
 	if w.cancel != nil {
 		// already running
 		return
 	}
+	if w.isUnsupported() {
+		return
+	}
 	wasStartedPreviously := w.wasStartedPreviously
 	var ctx context.Context
 	ctx, w.cancel = context.WithCancel(context.Background())
 	go func() {
 		defer w.cancel()
-		w.run(ctx, startTime, wasStartedPreviously)
+		w.supervise(ctx, startTime, wasStartedPreviously)
 	}()
 	w.wasStartedPreviously = true
 }
 
-func (w *Watcher) run(ctx context.Context, startTime time.Time, wasStartedPreviously bool) {
+// supervise runs w.run in a loop, reattaching whenever the log stream closes on its own or
+// goes idle while the container is still running. Both are symptoms of docker's json-file
+// driver rotating its log file out from under a long-lived `docker logs` connection.
+func (w *Watcher) supervise(ctx context.Context, startTime time.Time, wasStartedPreviously bool) {
+	since, first := startTime, wasStartedPreviously
+	for {
+		w.touchActivity()
+		w.primeLogSize(ctx)
+		runCtx, runCancel := context.WithCancel(ctx)
+		done := make(chan bool, 1)
+		go func() {
+			done <- w.run(runCtx, since, first)
+		}()
+		reconnect := w.waitForReattach(ctx, runCancel, done)
+		if !reconnect {
+			return
+		}
+		since, first = w.lastSeenOverall(), true
+	}
+}
+
+// waitForReattach blocks until w.run finishes on its own (returning its reconnect verdict) or
+// the container has gone idle for longer than idleReconnectThreshold *and* its log file has
+// picked up bytes we're not receiving, in which case it cancels the in-flight run and returns
+// true to force a reattach. The log-file check is what keeps a merely-quiet service (most
+// services, most of the time) from being torn down just because idleReconnectThreshold elapsed.
+func (w *Watcher) waitForReattach(ctx context.Context, runCancel context.CancelFunc, done chan bool) bool {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			runCancel()
+			<-done
+			return false
+		case reconnect := <-done:
+			runCancel()
+			return reconnect
+		case <-ticker.C:
+			idle := time.Since(w.activitySince())
+			if idle < idleCheckInterval {
+				// Data arrived since the last tick; move the log-size baseline past it so the
+				// growth check below only counts bytes written after the last line we received.
+				w.primeLogSize(ctx)
+			}
+			if idle < idleReconnectThreshold {
+				continue
+			}
+			if !w.containerRunning(ctx) {
+				continue
+			}
+			if !w.logFileGrewSinceLastCheck(ctx) {
+				// Nothing new has even been written to the log file; the service is just
+				// quiet, not stuck. Don't reattach a healthy connection.
+				continue
+			}
+			lg := alog.New(os.Stderr, getServiceLogPrefix(w.Service), 0)
+			lg.Printf("@(warn:log file has new data we're not receiving, likely rotated out from under us; reattaching)\n")
+			runCancel()
+			<-done
+			return true
+		}
+	}
+}
+
+// run attaches to the container's logs and streams them until the connection ends or ctx is
+// canceled. It returns true if the caller should reattach (the stream closed or was rotated out
+// from under us while the container is still running) and false if it should give up for good.
+func (w *Watcher) run(ctx context.Context, startTime time.Time, wasStartedPreviously bool) bool {
 	logsOpts := types.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Follow:     true,
+		Timestamps: true,
 	}
 	if wasStartedPreviously {
-		// This is far from perfect, but docker's API kind of sucks and this is pretty OK.
-		// When a container dies, ContainerLogs stops sending new logs. When the container
-		// is restarted, we get a new "start" event, but a new invocation of ContainerLogs
-		// can return logs from before the container died/stopped. So we try to filter the
-		// logs since the start time, but since this filter only has resolution in seconds
-		// and doesn't appear to strictly order the start event and first log message after
-		// start, we sometimes repeat log messages that were emitted just before the stop.
-		// :shrug:
-		logsOpts.Since = startTime.Add(-time.Second).UTC().Format("2006-01-02T15:04:05Z")
+		// When a container dies, ContainerLogs stops sending new logs. When the container is
+		// restarted, we get a new "start" event, but a new invocation of ContainerLogs can
+		// return logs from before the container died/stopped. We ask docker for everything
+		// since the later of the start event and the last line we actually forwarded, and then
+		// belt-and-suspenders filter out anything at or before that mark ourselves, since the
+		// `Since` semantics aren't strictly ordered with the first log line after a restart.
+		since := startTime
+		if lastSeen := w.lastSeenOverall(); lastSeen.After(since) {
+			since = lastSeen
+		}
+		logsOpts.Since = since.UTC().Format(time.RFC3339Nano)
 	} else {
-		logsOpts.Tail = "1000"
+		policy := policyFor(w.Service)
+		switch {
+		case policy.NoReplay:
+			logsOpts.Tail = "0"
+		case policy.Since > 0:
+			logsOpts.Since = time.Now().Add(-policy.Since).UTC().Format(time.RFC3339Nano)
+		default:
+			logsOpts.Tail = policy.Tail
+		}
 	}
-	logsReader, err := dockerClient.ContainerLogs(ctx, w.ContainerID, logsOpts)
-	if err != nil && strings.HasSuffix(err.Error(), context.Canceled.Error()) {
-		return
+	lg := alog.New(os.Stderr, getServiceLogPrefix(w.Service), 0)
+	backoff := reconnectInitialBackoff
+	var logsReader io.ReadCloser
+	for {
+		var err error
+		logsReader, err = dockerClient.ContainerLogs(ctx, w.ContainerID, logsOpts)
+		if err == nil {
+			break
+		}
+		if strings.HasSuffix(err.Error(), context.Canceled.Error()) {
+			return false
+		}
+		if errdefs.IsNotImplemented(err) {
+			lg.Printf("@(warn:logs not supported for this container (driver doesn't support `docker logs`); giving up): %v\n", err)
+			w.setUnsupported()
+			return false
+		}
+		// Treat anything else (connection refused, daemon restarting, etc.) as transient.
+		lg.Printf("@(warn:failed to attach to container logs, retrying in %s): %v\n", backoff, err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
 	}
-	alog.BailIf(err)
-	lgOut := alog.New(os.Stderr, getServiceLogPrefix(w.Service), 0)
-	lgErr := alog.New(os.Stderr, getServiceLogPrefix(w.Service), 0)
-	_, err = stdcopy.StdCopy(lgOut, lgErr, logsReader)
-	if err == context.Canceled {
-		return
+	outFilter := newDedupWriter(w, "stdout", newLineSink(w.Service, w.ContainerID, "stdout"))
+	errFilter := newDedupWriter(w, "stderr", newLineSink(w.Service, w.ContainerID, "stderr"))
+	_, err := stdcopy.StdCopy(outFilter, errFilter, logsReader)
+	logsReader.Close()
+	// The stream can end mid-line (normal EOF, stop(), or a chunk0-3 reattach); flush whatever's
+	// left in each buffer so the last line isn't silently dropped.
+	outFilter.Flush()
+	errFilter.Flush()
+	if ctx.Err() != nil {
+		return false
 	}
-	if err != nil {
+	if err != nil && err != io.EOF {
 		alog.Printf("@(warn:stdcopy.StdCopy failed: %v)\n", err)
+		return false
+	}
+	// The stream ended on its own (most likely docker rotated the container's json-file log
+	// out from under us). Reattach if the container is still around to produce more logs.
+	return w.containerRunning(ctx)
+}
+
+// dedupWriter parses the leading RFC3339Nano timestamp that docker prepends to each log line
+// (see Timestamps: true above), drops any line whose timestamp is at or before the stream's
+// high-water mark in w, and forwards the rest to sink. This suppresses the duplicate lines that
+// `Since` alone lets through around container restarts.
+type dedupWriter struct {
+	w      *Watcher
+	stream string
+	sink   lineSink
+	buf    bytes.Buffer
+}
+
+func newDedupWriter(w *Watcher, stream string, sink lineSink) *dedupWriter {
+	return &dedupWriter{w: w, stream: stream, sink: sink}
+}
+
+func (d *dedupWriter) Write(p []byte) (int, error) {
+	d.w.touchActivity()
+	d.buf.Write(p)
+	for {
+		line, err := d.buf.ReadBytes('\n')
+		if err != nil {
+			// incomplete line; put it back and wait for more data
+			d.buf.Write(line)
+			break
+		}
+		d.writeLine(line)
+	}
+	return len(p), nil
+}
+
+// Flush forwards any line left in the buffer with no trailing newline, e.g. because the stream
+// ended mid-line. It's a no-op if the buffer is empty.
+func (d *dedupWriter) Flush() {
+	if d.buf.Len() == 0 {
 		return
 	}
+	line := d.buf.Bytes()
+	d.buf.Reset()
+	d.writeLine(line)
+}
+
+func (d *dedupWriter) writeLine(line []byte) {
+	rest := line
+	ts, ok := parseLineTimestamp(line)
+	if ok {
+		if i := bytes.IndexByte(line, ' '); i >= 0 {
+			rest = line[i+1:]
+		}
+		if !ts.After(d.w.lastSeen(d.stream)) {
+			return
+		}
+		d.w.recordSeen(d.stream, ts)
+	}
+	d.sink.writeLine(ts, rest)
+}
+
+// parseLineTimestamp parses the RFC3339Nano timestamp docker prepends to each log line when
+// Timestamps: true is set, e.g. "2015-05-13T18:25:11.121876979Z the rest of the line\n".
+func parseLineTimestamp(line []byte) (time.Time, bool) {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(line[:i]))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
 }
 
 func (w *Watcher) stop() {
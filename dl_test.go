@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLineTimestamp(t *testing.T) {
+	cases := []struct {
+		name   string
+		line   []byte
+		wantT  time.Time
+		wantOK bool
+	}{
+		{
+			name:   "well formed",
+			line:   []byte("2015-05-13T18:25:11.121876979Z hello world\n"),
+			wantT:  time.Date(2015, 5, 13, 18, 25, 11, 121876979, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "no space",
+			line:   []byte("not-a-timestamp\n"),
+			wantOK: false,
+		},
+		{
+			name:   "unparseable timestamp",
+			line:   []byte("not-a-timestamp hello\n"),
+			wantOK: false,
+		},
+		{
+			name:   "empty",
+			line:   []byte(""),
+			wantOK: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gotT, gotOK := parseLineTimestamp(c.line)
+			if gotOK != c.wantOK {
+				t.Fatalf("parseLineTimestamp(%q) ok = %v, want %v", c.line, gotOK, c.wantOK)
+			}
+			if gotOK && !gotT.Equal(c.wantT) {
+				t.Fatalf("parseLineTimestamp(%q) = %v, want %v", c.line, gotT, c.wantT)
+			}
+		})
+	}
+}
+
+// fakeSink records every writeLine call for assertions.
+type fakeSink struct {
+	messages []string
+}
+
+func (f *fakeSink) writeLine(ts time.Time, message []byte) {
+	f.messages = append(f.messages, string(message))
+}
+
+func TestDedupWriterSuppressesDuplicatesAndOutOfOrderLines(t *testing.T) {
+	w := NewWatcher("web", "abc123")
+	sink := &fakeSink{}
+	d := newDedupWriter(w, "stdout", sink)
+
+	base := "2020-01-01T00:00:00.000000000Z"
+	lines := []string{
+		base + " first\n",
+		"2020-01-01T00:00:01.000000000Z second\n",
+		// a duplicate/earlier timestamp, as seen around container restarts
+		base + " first\n",
+		"2020-01-01T00:00:02.000000000Z third\n",
+	}
+	for _, line := range lines {
+		if _, err := d.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	want := []string{"first\n", "second\n", "third\n"}
+	if len(sink.messages) != len(want) {
+		t.Fatalf("got %d messages %v, want %d %v", len(sink.messages), sink.messages, len(want), want)
+	}
+	for i, m := range want {
+		if sink.messages[i] != m {
+			t.Errorf("message %d = %q, want %q", i, sink.messages[i], m)
+		}
+	}
+}
+
+func TestDedupWriterFlushesPartialLine(t *testing.T) {
+	w := NewWatcher("web", "abc123")
+	sink := &fakeSink{}
+	d := newDedupWriter(w, "stdout", sink)
+
+	if _, err := d.Write([]byte("2020-01-01T00:00:00.000000000Z no newline yet")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if len(sink.messages) != 0 {
+		t.Fatalf("expected no messages forwarded before Flush, got %v", sink.messages)
+	}
+	d.Flush()
+	if len(sink.messages) != 1 || sink.messages[0] != "no newline yet" {
+		t.Fatalf("got %v, want a single flushed message", sink.messages)
+	}
+	// Flushing an already-empty buffer is a no-op.
+	d.Flush()
+	if len(sink.messages) != 1 {
+		t.Fatalf("Flush on empty buffer should not forward anything, got %v", sink.messages)
+	}
+}
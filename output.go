@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tillberg/alog"
+)
+
+var (
+	outputFormat           = "text"
+	outputWriter io.Writer = os.Stdout
+	outputMu     sync.Mutex
+)
+
+// lineSink receives each complete, dedup-and-timestamp-stripped log line forwarded from a
+// single container/stream. newLineSink picks the implementation based on -format.
+type lineSink interface {
+	writeLine(ts time.Time, message []byte)
+}
+
+func newLineSink(service string, containerID string, stream string) lineSink {
+	switch outputFormat {
+	case "json":
+		return &jsonLineSink{service: service, containerID: containerID, stream: stream}
+	default:
+		return &textLineSink{lg: alog.New(os.Stderr, getServiceLogPrefix(service), 0)}
+	}
+}
+
+// textLineSink is the original behavior: write the raw message through an alog.Logger with the
+// service's colorized prefix.
+type textLineSink struct {
+	lg *alog.Logger
+}
+
+func (s *textLineSink) writeLine(ts time.Time, message []byte) {
+	s.lg.Write(message)
+}
+
+// jsonLogLine is the shape emitted by jsonLineSink, one object per log line. This makes `dl`
+// usable as a sidecar feeding fluent-bit/vector/beats.
+type jsonLogLine struct {
+	Timestamp   string `json:"ts"`
+	Service     string `json:"service"`
+	ContainerID string `json:"container_id"`
+	Stream      string `json:"stream"`
+	Message     string `json:"message"`
+}
+
+// jsonLineSink writes one JSON object per line to outputWriter. outputWriter is a package-level
+// var so a future -output <file> flag can redirect it without touching the encoding, and
+// outputMu serializes writes since every watcher's goroutines share it.
+type jsonLineSink struct {
+	service     string
+	containerID string
+	stream      string
+}
+
+func (s *jsonLineSink) writeLine(ts time.Time, message []byte) {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	b, err := json.Marshal(jsonLogLine{
+		Timestamp:   ts.UTC().Format(time.RFC3339Nano),
+		Service:     s.service,
+		ContainerID: s.containerID,
+		Stream:      s.stream,
+		Message:     string(bytes.TrimRight(message, "\n")),
+	})
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	outputWriter.Write(b)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJSONLineSinkWriteLine(t *testing.T) {
+	var buf bytes.Buffer
+
+	outputMu.Lock()
+	prevWriter := outputWriter
+	outputWriter = &buf
+	outputMu.Unlock()
+	defer func() {
+		outputMu.Lock()
+		outputWriter = prevWriter
+		outputMu.Unlock()
+	}()
+
+	sink := &jsonLineSink{service: "web", containerID: "abc123", stream: "stderr"}
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink.writeLine(ts, []byte("boom\n"))
+
+	var got jsonLogLine
+	if err := json.Unmarshal(bytes.TrimRight(buf.Bytes(), "\n"), &got); err != nil {
+		t.Fatalf("unmarshal %q: %v", buf.String(), err)
+	}
+	want := jsonLogLine{
+		Timestamp:   "2020-01-01T00:00:00Z",
+		Service:     "web",
+		ContainerID: "abc123",
+		Stream:      "stderr",
+		Message:     "boom",
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestJSONLineSinkWriteLineZeroTimestamp(t *testing.T) {
+	var buf bytes.Buffer
+	outputMu.Lock()
+	prevWriter := outputWriter
+	outputWriter = &buf
+	outputMu.Unlock()
+	defer func() {
+		outputMu.Lock()
+		outputWriter = prevWriter
+		outputMu.Unlock()
+	}()
+
+	sink := &jsonLineSink{service: "web", containerID: "abc123", stream: "stdout"}
+	sink.writeLine(time.Time{}, []byte("no timestamp available\n"))
+
+	var got jsonLogLine
+	if err := json.Unmarshal(bytes.TrimRight(buf.Bytes(), "\n"), &got); err != nil {
+		t.Fatalf("unmarshal %q: %v", buf.String(), err)
+	}
+	if got.Timestamp == "" || got.Timestamp[:4] == "0001" {
+		t.Fatalf("expected zero timestamp to be substituted with the current time, got %q", got.Timestamp)
+	}
+}
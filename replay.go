@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replayPolicy records a service's replay overrides as parsed from a service arg. Tail uses
+// "" as its unset sentinel (docker's Tail option is never an empty string), but Since and
+// NoReplay need an explicit tri-state: a service that writes "no-replay=false" must be able to
+// override a global "-no-replay=true", which a bool zero value can't distinguish from "service
+// didn't mention no-replay at all". Since and NoReplay are therefore pointers, set only when the
+// service arg actually names that key; see policyFor for how they're resolved against the
+// globals.
+type replayPolicy struct {
+	Tail     string
+	Since    *time.Duration
+	NoReplay *bool
+}
+
+// resolvedReplayPolicy is the effective, fully-defaulted policy for one service, as returned by
+// policyFor. Unlike replayPolicy, every field has a concrete value ready to feed into
+// ContainerLogsOptions.
+type resolvedReplayPolicy struct {
+	Tail     string
+	Since    time.Duration
+	NoReplay bool
+}
+
+var (
+	defaultTail     = "1000"
+	defaultSince    time.Duration
+	defaultNoReplay bool
+
+	servicePolicies = map[string]replayPolicy{}
+)
+
+// parseServiceArg splits a whitelist argument of the form "service" or "service:key=value"
+// (e.g. "api:tail=100", "worker:since=1h", "batch:no-replay=true") into the plain service name,
+// recording any override into servicePolicies as a side effect. It returns an error for a
+// malformed override rather than silently ignoring it, so a typo'd flag fails at startup
+// instead of quietly falling back to the default policy.
+func parseServiceArg(arg string) (string, error) {
+	parts := strings.SplitN(arg, ":", 2)
+	service := parts[0]
+	if len(parts) != 2 {
+		return service, nil
+	}
+	kv := strings.SplitN(parts[1], "=", 2)
+	if len(kv) != 2 {
+		return service, fmt.Errorf("invalid service argument %q: expected service:key=value", arg)
+	}
+	policy := servicePolicies[service]
+	switch kv[0] {
+	case "tail":
+		if !validTailValue(kv[1]) {
+			return service, fmt.Errorf("invalid tail override %q for service %q: must be \"all\" or a non-negative integer", kv[1], service)
+		}
+		policy.Tail = kv[1]
+	case "since":
+		d, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return service, fmt.Errorf("invalid since override %q for service %q: %v", kv[1], service, err)
+		}
+		policy.Since = &d
+	case "no-replay":
+		b, err := strconv.ParseBool(kv[1])
+		if err != nil {
+			return service, fmt.Errorf("invalid no-replay override %q for service %q: %v", kv[1], service, err)
+		}
+		policy.NoReplay = &b
+	default:
+		return service, fmt.Errorf("unknown override key %q for service %q", kv[0], service)
+	}
+	servicePolicies[service] = policy
+	return service, nil
+}
+
+// validTailValue reports whether v is an acceptable value for docker's `Tail` log option:
+// either "all" or a non-negative line count.
+func validTailValue(v string) bool {
+	if v == "all" {
+		return true
+	}
+	n, err := strconv.Atoi(v)
+	return err == nil && n >= 0
+}
+
+// policyFor resolves the effective replay policy for service, falling back to the global
+// -tail/-since/-no-replay flags for anything its per-service override didn't set. An explicit
+// per-service override always wins, even when it conflicts with the global flag (e.g. a service
+// arg of "api:no-replay=false" still replays history for api when -no-replay is set globally).
+func policyFor(service string) resolvedReplayPolicy {
+	policy := servicePolicies[service]
+	resolved := resolvedReplayPolicy{
+		Tail:     policy.Tail,
+		Since:    defaultSince,
+		NoReplay: defaultNoReplay,
+	}
+	if resolved.Tail == "" {
+		resolved.Tail = defaultTail
+	}
+	if policy.Since != nil {
+		resolved.Since = *policy.Since
+	}
+	if policy.NoReplay != nil {
+		resolved.NoReplay = *policy.NoReplay
+	}
+	return resolved
+}
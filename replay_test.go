@@ -0,0 +1,160 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func resetServicePolicies() {
+	servicePolicies = map[string]replayPolicy{}
+}
+
+// durPtr and boolPtr build the pointers replayPolicy's tri-state Since/NoReplay fields need.
+func durPtr(d time.Duration) *time.Duration { return &d }
+func boolPtr(b bool) *bool                  { return &b }
+
+// policiesEqual compares two replayPolicy values field-by-field, dereferencing the tri-state
+// pointers instead of comparing addresses.
+func policiesEqual(a, b replayPolicy) bool {
+	if a.Tail != b.Tail {
+		return false
+	}
+	if (a.Since == nil) != (b.Since == nil) || (a.Since != nil && *a.Since != *b.Since) {
+		return false
+	}
+	if (a.NoReplay == nil) != (b.NoReplay == nil) || (a.NoReplay != nil && *a.NoReplay != *b.NoReplay) {
+		return false
+	}
+	return true
+}
+
+func TestParseServiceArg(t *testing.T) {
+	cases := []struct {
+		name        string
+		arg         string
+		wantService string
+		wantPolicy  replayPolicy
+		wantErr     bool
+	}{
+		{name: "plain service", arg: "api", wantService: "api"},
+		{name: "tail override", arg: "api:tail=100", wantService: "api", wantPolicy: replayPolicy{Tail: "100"}},
+		{name: "tail all", arg: "api:tail=all", wantService: "api", wantPolicy: replayPolicy{Tail: "all"}},
+		{name: "since override", arg: "worker:since=1h", wantService: "worker", wantPolicy: replayPolicy{Since: durPtr(time.Hour)}},
+		{name: "no-replay override", arg: "batch:no-replay=true", wantService: "batch", wantPolicy: replayPolicy{NoReplay: boolPtr(true)}},
+		{name: "no-replay false override", arg: "batch:no-replay=false", wantService: "batch", wantPolicy: replayPolicy{NoReplay: boolPtr(false)}},
+		{name: "bad tail", arg: "api:tail=-5", wantErr: true},
+		{name: "bad since", arg: "worker:since=soon", wantErr: true},
+		{name: "bad no-replay", arg: "batch:no-replay=yep", wantErr: true},
+		{name: "unknown key", arg: "api:color=blue", wantErr: true},
+		{name: "missing value", arg: "api:tail", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resetServicePolicies()
+			service, err := parseServiceArg(c.arg)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseServiceArg(%q) = nil error, want an error", c.arg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseServiceArg(%q) unexpected error: %v", c.arg, err)
+			}
+			if service != c.wantService {
+				t.Fatalf("parseServiceArg(%q) service = %q, want %q", c.arg, service, c.wantService)
+			}
+			if got := servicePolicies[service]; !policiesEqual(got, c.wantPolicy) {
+				t.Fatalf("parseServiceArg(%q) policy = %+v, want %+v", c.arg, got, c.wantPolicy)
+			}
+		})
+	}
+}
+
+func TestPolicyForPrecedence(t *testing.T) {
+	origTail, origSince, origNoReplay := defaultTail, defaultSince, defaultNoReplay
+	defer func() {
+		defaultTail, defaultSince, defaultNoReplay = origTail, origSince, origNoReplay
+		resetServicePolicies()
+	}()
+
+	defaultTail = "1000"
+	defaultSince = 0
+	defaultNoReplay = false
+
+	t.Run("falls back to global defaults", func(t *testing.T) {
+		resetServicePolicies()
+		got := policyFor("unconfigured")
+		want := resolvedReplayPolicy{Tail: "1000", Since: 0, NoReplay: false}
+		if got != want {
+			t.Fatalf("policyFor = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("per-service tail overrides global tail", func(t *testing.T) {
+		resetServicePolicies()
+		servicePolicies["api"] = replayPolicy{Tail: "50"}
+		got := policyFor("api")
+		if got.Tail != "50" {
+			t.Fatalf("Tail = %q, want %q", got.Tail, "50")
+		}
+	})
+
+	t.Run("per-service since overrides global tail default", func(t *testing.T) {
+		resetServicePolicies()
+		servicePolicies["worker"] = replayPolicy{Since: durPtr(30 * time.Minute)}
+		got := policyFor("worker")
+		if got.Since != 30*time.Minute {
+			t.Fatalf("Since = %v, want %v", got.Since, 30*time.Minute)
+		}
+		// Tail still falls back to the global default even though Since is set; it's run's
+		// job to prefer NoReplay > Since > Tail when building ContainerLogsOptions.
+		if got.Tail != "1000" {
+			t.Fatalf("Tail = %q, want global default %q", got.Tail, "1000")
+		}
+	})
+
+	t.Run("global -no-replay applies when service doesn't override it", func(t *testing.T) {
+		resetServicePolicies()
+		defaultNoReplay = true
+		defer func() { defaultNoReplay = false }()
+		got := policyFor("anything")
+		if !got.NoReplay {
+			t.Fatalf("NoReplay = false, want true (from global -no-replay)")
+		}
+	})
+
+	t.Run("per-service no-replay=false overrides a conflicting global -no-replay=true", func(t *testing.T) {
+		resetServicePolicies()
+		defaultNoReplay = true
+		defer func() { defaultNoReplay = false }()
+		servicePolicies["api"] = replayPolicy{NoReplay: boolPtr(false)}
+		got := policyFor("api")
+		if got.NoReplay {
+			t.Fatalf("NoReplay = true, want false (explicit per-service override of the global)")
+		}
+		// An unrelated service with no override still gets the global default.
+		if got := policyFor("other"); !got.NoReplay {
+			t.Fatalf("NoReplay for unconfigured service = false, want true (from global -no-replay)")
+		}
+	})
+}
+
+func TestValidTailValue(t *testing.T) {
+	cases := []struct {
+		value string
+		want  bool
+	}{
+		{"all", true},
+		{"0", true},
+		{"1000", true},
+		{"-1", false},
+		{"abc", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := validTailValue(c.value); got != c.want {
+			t.Errorf("validTailValue(%q) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/tillberg/alog"
+)
+
+var (
+	watchComposeFile string
+	syncFlags        syncRules
+	rebuildFlags     rebuildRules
+)
+
+// syncRule keeps a host path in sync with a path inside a service's container(s), the way
+// `docker compose watch`'s `sync` action does, without requiring a rebuild.
+type syncRule struct {
+	Service       string
+	HostPath      string
+	ContainerPath string
+}
+
+// syncRules accumulates -sync flags.
+type syncRules []syncRule
+
+func (s *syncRules) String() string {
+	parts := make([]string, len(*s))
+	for i, r := range *s {
+		parts[i] = fmt.Sprintf("%s:%s:%s", r.Service, r.HostPath, r.ContainerPath)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *syncRules) Set(value string) error {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("-sync must be service:hostPath:containerPath, got %q", value)
+	}
+	*s = append(*s, syncRule{Service: parts[0], HostPath: parts[1], ContainerPath: parts[2]})
+	return nil
+}
+
+// rebuildRule triggers a `docker compose up --build` for Service whenever a file matching Glob
+// changes, the way `docker compose watch`'s `rebuild` action does.
+type rebuildRule struct {
+	Service string
+	Glob    string
+}
+
+// rebuildRules accumulates -rebuild-on flags.
+type rebuildRules []rebuildRule
+
+func (r *rebuildRules) String() string {
+	parts := make([]string, len(*r))
+	for i, rule := range *r {
+		parts[i] = fmt.Sprintf("%s:%s", rule.Service, rule.Glob)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *rebuildRules) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("-rebuild-on must be service:glob, got %q", value)
+	}
+	*r = append(*r, rebuildRule{Service: parts[0], Glob: parts[1]})
+	return nil
+}
+
+// watchSources watches the host paths named by -sync and -rebuild-on and, on change, either
+// docker-cp's the file into the matching service's container(s) or rebuilds the service via
+// `docker compose up --build`. Container replacement is handled for free: compose's "start"
+// event for the new container ID flows through containerStart like any other restart, so log
+// streaming hands off without gaps.
+func watchSources(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	alog.BailIf(err)
+	defer watcher.Close()
+	watchedDirs := map[string]bool{}
+	for _, rule := range syncFlags {
+		addRecursive(watcher, watchedDirs, rule.HostPath)
+	}
+	for _, rule := range rebuildFlags {
+		addRecursive(watcher, watchedDirs, globBaseDir(rule.Glob))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				// Either a new subdirectory to descend into, or an editor's rename-into-place
+				// swapping out an inode whose parent we already watch; addRecursive no-ops on
+				// anything we've already added.
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					addRecursive(watcher, watchedDirs, ev.Name)
+				}
+			}
+			handleSourceChange(ev.Name)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			alog.Printf("@(warn:watch error): %v\n", err)
+		}
+	}
+}
+
+// globBaseDir returns the deepest ancestor of glob that contains no glob metacharacters, so it
+// can be passed to fsnotify.Add directly, e.g. "/app/src/**/*.go" -> "/app/src".
+func globBaseDir(glob string) string {
+	dir := filepath.Dir(glob)
+	for strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// addRecursive watches root and every directory beneath it, since fsnotify.Add only watches a
+// single directory's immediate entries; without this, changes in any subdirectory of a real
+// source tree would never be observed. It also watches root's parent: editors that save via
+// temp-file-then-rename (vim, many IDEs/LSPs) replace the watched directory's inode on the first
+// such save, which silently drops a watch added on the directory itself, so we rely on the
+// parent's directory-entry events to notice the replacement. Directories already in watched are
+// skipped.
+func addRecursive(watcher *fsnotify.Watcher, watched map[string]bool, root string) {
+	if parent := filepath.Dir(root); !watched[parent] {
+		if err := watcher.Add(parent); err != nil {
+			alog.Printf("@(warn:failed to watch %s): %v\n", parent, err)
+		} else {
+			watched[parent] = true
+		}
+	}
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || watched[path] {
+			return nil
+		}
+		if err := watcher.Add(path); err != nil {
+			alog.Printf("@(warn:failed to watch %s): %v\n", path, err)
+			return nil
+		}
+		watched[path] = true
+		return nil
+	})
+}
+
+func handleSourceChange(hostPath string) {
+	for _, rule := range syncFlags {
+		rel, ok := relUnder(rule.HostPath, hostPath)
+		if !ok {
+			continue
+		}
+		dest := rule.ContainerPath
+		if rel != "" {
+			dest = dest + "/" + rel
+		}
+		copyToService(rule.Service, hostPath, dest)
+	}
+	for _, rule := range rebuildFlags {
+		if matched, _ := filepath.Match(rule.Glob, hostPath); matched {
+			rebuildService(rule.Service)
+		}
+	}
+}
+
+// relUnder reports whether hostPath is root itself or lies under it as a real path segment, not
+// merely sharing a string prefix (so a rule rooted at "/app/src" doesn't also match
+// "/app/src-backup/..."), returning hostPath's slash-separated path relative to root.
+func relUnder(root, hostPath string) (string, bool) {
+	rel, err := filepath.Rel(root, hostPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// copyToService docker-cp's hostPath into containerPath inside every container we're currently
+// watching for the given service.
+func copyToService(service string, hostPath string, containerPath string) {
+	watchersMutex.Lock()
+	ids := make([]string, 0, len(watchers))
+	for id, w := range watchers {
+		// Stopped watchers stay in the map (stop only clears cancel); skip them.
+		if w.Service == service && w.cancel != nil {
+			ids = append(ids, id)
+		}
+	}
+	watchersMutex.Unlock()
+	for _, id := range ids {
+		cmd := exec.Command("docker", "cp", hostPath, id+":"+containerPath)
+		// These are diagnostic, not the log stream itself: os.Stdout is reserved for
+		// jsonLineSink's structured output (see output.go), so this subprocess's own output
+		// always goes to stderr, even in -format json mode.
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			alog.Printf("@(warn:docker cp to %s failed): %v\n", id, err)
+		}
+	}
+}
+
+// rebuildService runs `docker compose up --build -d <service>` against -watch's compose file.
+func rebuildService(service string) {
+	lg := alog.New(os.Stderr, getServiceLogPrefix(service), 0)
+	if watchComposeFile == "" {
+		lg.Printf("@(warn:source changed but no -watch compose file was given; skipping rebuild)\n")
+		return
+	}
+	lg.Printf("@(yellow:rebuilding) @(dim:source changed)\n")
+	cmd := exec.Command("docker", "compose", "-f", watchComposeFile, "up", "--build", "-d", service)
+	// os.Stdout is reserved for jsonLineSink's structured output (see output.go), so this
+	// subprocess's own output always goes to stderr, even in -format json mode.
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		lg.Printf("@(warn:rebuild failed): %v\n", err)
+	}
+}